@@ -0,0 +1,103 @@
+// Package amazon verifies Amazon Appstore in-app purchases via the Receipt
+// Verification Service (RVS).
+package amazon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/xsean2020/apple/iap"
+)
+
+const defaultBaseURL = "https://appstore-sdk.amazon.com"
+
+// Client verifies receipts against Amazon's Receipt Verification Service.
+type Client struct {
+	// SharedSecret is the developer secret from the Amazon Appstore console.
+	SharedSecret string
+	// BaseURL defaults to Amazon's production RVS host; override for testing.
+	BaseURL string
+
+	httpCli *http.Client
+}
+
+// New creates a Client for the given developer shared secret.
+func New(sharedSecret string) *Client {
+	return &Client{
+		SharedSecret: sharedSecret,
+		BaseURL:      defaultBaseURL,
+		httpCli:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Receipt is the response of verifyReceiptId.
+type Receipt struct {
+	ReceiptID      string `json:"receiptId"`
+	ProductType    string `json:"productType"`
+	ProductID      string `json:"productId"`
+	PurchaseDate   int64  `json:"purchaseDate"`
+	RenewalDate    int64  `json:"renewalDate,omitempty"`
+	CancelDate     int64  `json:"cancelDate,omitempty"`
+	AppPackageName string `json:"appPackageName"`
+	Quantity       int    `json:"quantity"`
+	Term           string `json:"term,omitempty"`
+	TermSku        string `json:"termSku,omitempty"`
+}
+
+// VerifyReceiptId calls RVS's verifyReceiptId endpoint for a single receipt.
+func (c *Client) VerifyReceiptId(ctx context.Context, userID, receiptID string) (*Receipt, error) {
+	u := fmt.Sprintf("%s/version/1.0/verifyReceiptId/developer/%s/user/%s/receiptId/%s",
+		c.BaseURL, url.PathEscape(c.SharedSecret), url.PathEscape(userID), url.PathEscape(receiptID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("amazon rvs: status %d: %s", resp.StatusCode, buf)
+	}
+
+	out := new(Receipt)
+	if err := json.Unmarshal(buf, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Verify implements iap.Verifier. req.TransactionID is the Amazon user ID
+// and req.ReceiptData is the receipt ID reported by the client SDK.
+func (c *Client) Verify(ctx context.Context, req iap.VerifyRequest) (*iap.UnifiedReceipt, error) {
+	r, err := c.VerifyReceiptId(ctx, req.TransactionID, req.ReceiptData)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &iap.UnifiedReceipt{
+		Store:         "amazon",
+		ProductID:     r.ProductID,
+		TransactionID: r.ReceiptID,
+		PurchaseTime:  time.UnixMilli(r.PurchaseDate),
+		AutoRenewing:  r.ProductType == "SUBSCRIPTION" && r.CancelDate == 0,
+		Raw:           r,
+	}
+	if r.RenewalDate > 0 {
+		ret.ExpiryTime = time.UnixMilli(r.RenewalDate)
+	}
+	return ret, nil
+}
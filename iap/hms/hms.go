@@ -0,0 +1,170 @@
+// Package hms verifies Huawei in-app purchases using HMS IAP's order query API.
+package hms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/xsean2020/apple/iap"
+)
+
+const (
+	tokenURL = "https://oauth-login.cloud.huawei.com/oauth2/v3/token"
+	orderURL = "https://orders.athub.dbankcloud.com/applicationstore/ordersvcs/purchaseTokens/order/v3"
+)
+
+// Config holds the OAuth client credentials issued by AppGallery Connect.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// Client verifies purchases against Huawei's HMS IAP order query API,
+// minting and caching its own OAuth2 access token.
+type Client struct {
+	cfg     Config
+	httpCli *http.Client
+
+	mu       sync.Mutex
+	token    string
+	tokenExp time.Time
+}
+
+// New creates a Client for the given OAuth client credentials.
+func New(cfg Config) *Client {
+	return &Client{cfg: cfg, httpCli: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExp) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, bytes.NewReader([]byte(form.Encode())))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+
+	c.token = tok.AccessToken
+	c.tokenExp = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+// PurchaseData is the decoded purchaseTokenData JSON embedded in an order
+// query response.
+type PurchaseData struct {
+	ProductID      string `json:"productId"`
+	PurchaseToken  string `json:"purchaseToken"`
+	OrderID        string `json:"orderId"`
+	PurchaseTime   int64  `json:"purchaseTime"`
+	PurchaseState  int    `json:"purchaseState"`
+	AutoRenewing   bool   `json:"autoRenewing"`
+	ExpirationDate int64  `json:"expirationDate,omitempty"`
+}
+
+type orderQueryResponse struct {
+	ResponseCode      string `json:"responseCode"`
+	ResponseMessage   string `json:"responseMessage"`
+	PurchaseTokenData string `json:"purchaseTokenData"`
+}
+
+// QueryOrder calls HMS IAP's purchaseTokens/order/v3 endpoint.
+func (c *Client) QueryOrder(ctx context.Context, purchaseToken, productID string) (*PurchaseData, error) {
+	tok, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"purchaseToken": purchaseToken,
+		"productId":     productID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orderURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+tok)
+
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var out orderQueryResponse
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return nil, err
+	}
+	if out.ResponseCode != "0" {
+		return nil, fmt.Errorf("hms iap: responseCode %s: %s", out.ResponseCode, out.ResponseMessage)
+	}
+
+	data := new(PurchaseData)
+	if err := json.Unmarshal([]byte(out.PurchaseTokenData), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Verify implements iap.Verifier. req.ReceiptData is the purchase token and
+// req.ProductID is the product ID reported by the client SDK.
+func (c *Client) Verify(ctx context.Context, req iap.VerifyRequest) (*iap.UnifiedReceipt, error) {
+	d, err := c.QueryOrder(ctx, req.ReceiptData, req.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &iap.UnifiedReceipt{
+		Store:        "hms",
+		ProductID:    d.ProductID,
+		OrderID:      d.OrderID,
+		PurchaseTime: time.UnixMilli(d.PurchaseTime),
+		AutoRenewing: d.AutoRenewing,
+		Raw:          d,
+	}
+	if d.ExpirationDate > 0 {
+		ret.ExpiryTime = time.UnixMilli(d.ExpirationDate)
+	}
+	return ret, nil
+}
@@ -0,0 +1,54 @@
+package iap
+
+// IAPRequest is the JSON body sent to Apple's verifyReceipt endpoint.
+type IAPRequest struct {
+	ReceiptData            string `json:"receipt-data"`
+	Password               string `json:"password,omitempty"`
+	ExcludeOldTransactions bool   `json:"exclude-old-transactions,omitempty"`
+}
+
+// StatusResponse is the minimal shape needed to inspect verifyReceipt's
+// status before deciding whether the sandbox fallback in parseResponse
+// applies.
+type StatusResponse struct {
+	Status int `json:"status"`
+}
+
+// IAPResponse is the decoded verifyReceipt response.
+type IAPResponse struct {
+	Status      int     `json:"status"`
+	Environment string  `json:"environment"`
+	Receipt     Receipt `json:"receipt"`
+
+	// ServedBy records which environment ("production" or "sandbox")
+	// actually answered this request, as opposed to Environment, which is
+	// Apple's own field describing which environment issued the receipt
+	// being verified. They differ when parseResponse falls back to the
+	// sandbox host after a 21007 status. Not part of Apple's JSON payload.
+	ServedBy string `json:"-"`
+}
+
+// Receipt is the decoded "receipt" object of a verifyReceipt response.
+type Receipt struct {
+	BundleID                   string  `json:"bundle_id"`
+	ApplicationVersion         string  `json:"application_version"`
+	OriginalApplicationVersion string  `json:"original_application_version"`
+	ReceiptCreationDate        string  `json:"receipt_creation_date"`
+	ExpirationDate             string  `json:"expiration_date,omitempty"`
+	InApp                      []InApp `json:"in_app"`
+}
+
+// InApp is a single in-app purchase entry in a verifyReceipt response.
+type InApp struct {
+	Quantity              string `json:"quantity"`
+	ProductID             string `json:"product_id"`
+	TransactionID         string `json:"transaction_id"`
+	OriginalTransactionID string `json:"original_transaction_id"`
+	PurchaseDate          string `json:"purchase_date"`
+	OriginalPurchaseDate  string `json:"original_purchase_date"`
+	ExpiresDate           string `json:"expires_date,omitempty"`
+	WebOrderLineItemID    string `json:"web_order_line_item_id,omitempty"`
+	CancellationDate      string `json:"cancellation_date,omitempty"`
+	IsInIntroOfferPeriod  string `json:"is_in_intro_offer_period,omitempty"`
+	IsTrialPeriod         string `json:"is_trial_period,omitempty"`
+}
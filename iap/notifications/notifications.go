@@ -0,0 +1,348 @@
+// Package notifications decodes Apple's App Store Server Notifications V2
+// payloads: the signed webhook body Apple posts to a developer's configured
+// URL whenever a subscription or transaction changes state.
+package notifications
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// AppleRootCA-G3.pem must hold the genuine Apple Root CA - G3 certificate,
+// downloaded from https://www.apple.com/certificateauthority/ - every
+// production x5c chain terminates there, and nowhere else will verify.
+//
+//go:embed AppleRootCA-G3.pem
+var appleRootCAG3PEM []byte
+
+// DefaultAppleRootCAG3 returns the embedded Apple Root CA - G3 certificate,
+// the trust anchor the x5c chain on every App Store Server API and Server
+// Notifications V2 JWS terminates at. It is a different certificate from
+// receipt.DefaultAppleRootCert, which only anchors the PKCS#7 signing chain
+// on offline receipts.
+func DefaultAppleRootCAG3() (*x509.Certificate, error) {
+	block, _ := pem.Decode(appleRootCAG3PEM)
+	if block == nil {
+		return nil, errors.New("notifications: failed to decode embedded root certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// Documented notificationType values.
+const (
+	NotificationTypeSubscribed             = "SUBSCRIBED"
+	NotificationTypeDidChangeRenewalPref   = "DID_CHANGE_RENEWAL_PREF"
+	NotificationTypeDidChangeRenewalStatus = "DID_CHANGE_RENEWAL_STATUS"
+	NotificationTypeOfferRedeemed          = "OFFER_REDEEMED"
+	NotificationTypeDidRenew               = "DID_RENEW"
+	NotificationTypeExpired                = "EXPIRED"
+	NotificationTypeDidFailToRenew         = "DID_FAIL_TO_RENEW"
+	NotificationTypeGracePeriodExpired     = "GRACE_PERIOD_EXPIRED"
+	NotificationTypePriceIncrease          = "PRICE_INCREASE"
+	NotificationTypeRefund                 = "REFUND"
+	NotificationTypeRefundDeclined         = "REFUND_DECLINED"
+	NotificationTypeRefundReversed         = "REFUND_REVERSED"
+	NotificationTypeConsumptionRequest     = "CONSUMPTION_REQUEST"
+	NotificationTypeRenewalExtended        = "RENEWAL_EXTENDED"
+	NotificationTypeRenewalExtension       = "RENEWAL_EXTENSION"
+	NotificationTypeRevoke                 = "REVOKE"
+	NotificationTypeTest                   = "TEST"
+)
+
+// Documented subtype values.
+const (
+	SubtypeInitialBuy        = "INITIAL_BUY"
+	SubtypeResubscribe       = "RESUBSCRIBE"
+	SubtypeDowngrade         = "DOWNGRADE"
+	SubtypeUpgrade           = "UPGRADE"
+	SubtypeAutoRenewEnabled  = "AUTO_RENEW_ENABLED"
+	SubtypeAutoRenewDisabled = "AUTO_RENEW_DISABLED"
+	SubtypeVoluntary         = "VOLUNTARY"
+	SubtypeBillingRetry      = "BILLING_RETRY"
+	SubtypePriceIncrease     = "PRICE_INCREASE"
+	SubtypeGracePeriod       = "GRACE_PERIOD"
+	SubtypeBillingRecovery   = "BILLING_RECOVERY"
+	SubtypePending           = "PENDING"
+	SubtypeAccepted          = "ACCEPTED"
+	SubtypeFailure           = "FAILURE"
+	SubtypeUnreported        = "UNREPORTED"
+)
+
+var (
+	// ErrMalformedJWS is returned when a payload is not a well-formed
+	// compact JWS (header.payload.signature).
+	ErrMalformedJWS = errors.New("notifications: malformed JWS")
+	// ErrUntrustedSignature is returned when a JWS's x5c chain does not
+	// terminate at Apple's root certificate, or the signature is invalid.
+	ErrUntrustedSignature = errors.New("notifications: signature does not chain to a trusted root")
+)
+
+// ResponseBodyV2 is the outer envelope Apple posts to the notification URL.
+type ResponseBodyV2 struct {
+	SignedPayload string `json:"signedPayload"`
+}
+
+// ResponseBodyV2DecodedPayload is the decoded payload of a V2 notification.
+type ResponseBodyV2DecodedPayload struct {
+	NotificationType string           `json:"notificationType"`
+	Subtype          string           `json:"subtype,omitempty"`
+	NotificationUUID string           `json:"notificationUUID"`
+	Data             NotificationData `json:"data"`
+	Summary          *SummaryInfo     `json:"summary,omitempty"`
+	Version          string           `json:"version"`
+	SignedDate       int64            `json:"signedDate"`
+
+	// DecodedTransaction and DecodedRenewalInfo hold the result of verifying
+	// and decoding Data.SignedTransactionInfo / Data.SignedRenewalInfo, when
+	// present.
+	DecodedTransaction *JWSTransactionDecodedPayload `json:"-"`
+	DecodedRenewalInfo *JWSRenewalInfoDecodedPayload `json:"-"`
+}
+
+// NotificationData carries the nested JWS-signed transaction and renewal
+// info, plus identifying fields about the app the notification is for.
+type NotificationData struct {
+	AppAppleID            int64  `json:"appAppleId"`
+	BundleID              string `json:"bundleId"`
+	BundleVersion         string `json:"bundleVersion"`
+	Environment           string `json:"environment"`
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+	SignedRenewalInfo     string `json:"signedRenewalInfo,omitempty"`
+}
+
+// SummaryInfo is included on mass-update notifications (e.g. RENEWAL_EXTENDED).
+type SummaryInfo struct {
+	RequestIdentifier string `json:"requestIdentifier"`
+	Environment       string `json:"environment"`
+	AppAppleID        int64  `json:"appAppleId"`
+	BundleID          string `json:"bundleId"`
+	ProductID         string `json:"productId"`
+	Upgraded          bool   `json:"upgraded,omitempty"`
+	FailedCount       int64  `json:"failedCount"`
+	SucceededCount    int64  `json:"succeededCount"`
+}
+
+// JWSTransactionDecodedPayload is the decoded payload of a signedTransactionInfo JWS.
+type JWSTransactionDecodedPayload struct {
+	TransactionID               string `json:"transactionId"`
+	OriginalTransactionID       string `json:"originalTransactionId"`
+	WebOrderLineItemID          string `json:"webOrderLineItemId"`
+	BundleID                    string `json:"bundleId"`
+	ProductID                   string `json:"productId"`
+	SubscriptionGroupIdentifier string `json:"subscriptionGroupIdentifier"`
+	PurchaseDate                int64  `json:"purchaseDate"`
+	OriginalPurchaseDate        int64  `json:"originalPurchaseDate"`
+	ExpiresDate                 int64  `json:"expiresDate,omitempty"`
+	Quantity                    int    `json:"quantity"`
+	Type                        string `json:"type"`
+	AppAccountToken             string `json:"appAccountToken,omitempty"`
+	InAppOwnershipType          string `json:"inAppOwnershipType"`
+	SignedDate                  int64  `json:"signedDate"`
+	OfferType                   int    `json:"offerType,omitempty"`
+	OfferIdentifier             string `json:"offerIdentifier,omitempty"`
+	Environment                 string `json:"environment"`
+	TransactionReason           string `json:"transactionReason,omitempty"`
+	Storefront                  string `json:"storefront,omitempty"`
+	StorefrontID                string `json:"storefrontId,omitempty"`
+	Price                       int64  `json:"price,omitempty"`
+	Currency                    string `json:"currency,omitempty"`
+}
+
+// JWSRenewalInfoDecodedPayload is the decoded payload of a signedRenewalInfo JWS.
+type JWSRenewalInfoDecodedPayload struct {
+	ExpirationIntent            int    `json:"expirationIntent,omitempty"`
+	OriginalTransactionID       string `json:"originalTransactionId"`
+	AutoRenewProductID          string `json:"autoRenewProductId"`
+	ProductID                   string `json:"productId"`
+	AutoRenewStatus             int    `json:"autoRenewStatus"`
+	IsInBillingRetryPeriod      bool   `json:"isInBillingRetryPeriod,omitempty"`
+	PriceIncreaseStatus         int    `json:"priceIncreaseStatus,omitempty"`
+	GracePeriodExpiresDate      int64  `json:"gracePeriodExpiresDate,omitempty"`
+	OfferType                   int    `json:"offerType,omitempty"`
+	OfferIdentifier             string `json:"offerIdentifier,omitempty"`
+	SignedDate                  int64  `json:"signedDate"`
+	Environment                 string `json:"environment"`
+	RecentSubscriptionStartDate int64  `json:"recentSubscriptionStartDate"`
+	RenewalDate                 int64  `json:"renewalDate,omitempty"`
+}
+
+// DecodeJWS verifies and decodes a standalone compact JWS document - such as
+// the signedTransactionInfo/signedRenewalInfo/signedTransactions strings the
+// App Store Server API returns - against rootCert, the same way Parse
+// verifies the JWS payloads nested inside a notification.
+func DecodeJWS(token string, rootCert *x509.Certificate, out interface{}) error {
+	pool := x509.NewCertPool()
+	pool.AddCert(rootCert)
+	return decodeJWS(token, pool, out)
+}
+
+// Parse verifies and decodes the raw body of an App Store Server
+// Notifications V2 request. rootCert is the trust anchor each JWS's x5c
+// chain must terminate at; pass DefaultAppleRootCAG3() unless testing
+// against a different chain.
+func Parse(body []byte, rootCert *x509.Certificate) (*ResponseBodyV2DecodedPayload, error) {
+	var envelope ResponseBodyV2
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("notifications: failed to decode request body: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(rootCert)
+
+	payload := new(ResponseBodyV2DecodedPayload)
+	if err := decodeJWS(envelope.SignedPayload, pool, payload); err != nil {
+		return nil, err
+	}
+
+	if payload.Data.SignedTransactionInfo != "" {
+		tx := new(JWSTransactionDecodedPayload)
+		if err := decodeJWS(payload.Data.SignedTransactionInfo, pool, tx); err != nil {
+			return nil, err
+		}
+		payload.DecodedTransaction = tx
+	}
+
+	if payload.Data.SignedRenewalInfo != "" {
+		renewal := new(JWSRenewalInfoDecodedPayload)
+		if err := decodeJWS(payload.Data.SignedRenewalInfo, pool, renewal); err != nil {
+			return nil, err
+		}
+		payload.DecodedRenewalInfo = renewal
+	}
+
+	return payload, nil
+}
+
+// decodeJWS verifies a compact JWS's ES256 signature against the leaf
+// certificate of its x5c header chain, checks that chain against pool, and
+// unmarshals the payload into out.
+func decodeJWS(token string, pool *x509.CertPool, out interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformedJWS
+	}
+
+	headerBts, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedJWS, err)
+	}
+
+	var header struct {
+		Alg string   `json:"alg"`
+		X5c []string `json:"x5c"`
+	}
+	if err := json.Unmarshal(headerBts, &header); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedJWS, err)
+	}
+	if len(header.X5c) == 0 {
+		return fmt.Errorf("%w: missing x5c header", ErrMalformedJWS)
+	}
+
+	leaf, err := verifyX5c(header.X5c, pool)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedJWS, err)
+	}
+
+	pub, ok := leaf.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: leaf certificate is not ECDSA", ErrUntrustedSignature)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return fmt.Errorf("%w: unexpected signature length", ErrUntrustedSignature)
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	if !ecdsa.Verify(pub, hash[:], r, s) {
+		return fmt.Errorf("%w: invalid signature", ErrUntrustedSignature)
+	}
+
+	payloadBts, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedJWS, err)
+	}
+	if err := json.Unmarshal(payloadBts, out); err != nil {
+		return fmt.Errorf("notifications: failed to decode JWS payload: %w", err)
+	}
+	return nil
+}
+
+// verifyX5c parses the base64-encoded x5c certificate chain and verifies it
+// terminates at a certificate in pool, returning the leaf (signing) certificate.
+func verifyX5c(x5c []string, pool *x509.CertPool) (*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(x5c))
+	for _, b64 := range x5c {
+		der, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedJWS, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedJWS, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: pool, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUntrustedSignature, err)
+	}
+	return leaf, nil
+}
+
+// Handler wraps fn into a ready-made webhook endpoint: it parses and
+// verifies the posted notification using DefaultAppleRootCAG3 as the trust
+// anchor, calls fn, and maps the result to an HTTP status: 200 on success,
+// 500 when fn returns an error (so Apple retries delivery), and 400 when the
+// body cannot be read or fails to parse or verify.
+func Handler(fn func(context.Context, *ResponseBodyV2DecodedPayload) error) (http.Handler, error) {
+	rootCert, err := DefaultAppleRootCAG3()
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		payload, err := Parse(body, rootCert)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := fn(r.Context(), payload); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}), nil
+}
@@ -0,0 +1,173 @@
+package iap
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RetryPolicy configures how Client retries a request that failed with a
+// 5xx response or a network error.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// BaseDelay is the starting backoff delay; it doubles on every retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay before jitter is applied.
+	MaxDelay time.Duration
+	// PerAttemptTimeout bounds a single attempt, independent of the
+	// context passed to Verify. Zero disables the per-attempt timeout.
+	PerAttemptTimeout time.Duration
+}
+
+// DefaultRetryPolicy is used by New, NewWithClient and NewWithOptions when
+// WithRetry is not supplied.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:       3,
+	BaseDelay:         200 * time.Millisecond,
+	MaxDelay:          5 * time.Second,
+	PerAttemptTimeout: 10 * time.Second,
+}
+
+// Observer lets callers hook into the lifecycle of a request to the App
+// Store, e.g. to export Prometheus or OpenTelemetry metrics, without this
+// package depending on any particular instrumentation library.
+type Observer interface {
+	OnRequest(ctx context.Context, url string)
+	OnResponse(ctx context.Context, url string, statusCode int, dur time.Duration)
+	OnRetry(ctx context.Context, url string, attempt int, err error)
+	OnError(ctx context.Context, url string, err error)
+}
+
+// NopObserver implements Observer with no-ops. It is the default observer
+// so callers only need to override the hooks they care about by embedding
+// it in their own type.
+type NopObserver struct{}
+
+func (NopObserver) OnRequest(context.Context, string)                      {}
+func (NopObserver) OnResponse(context.Context, string, int, time.Duration) {}
+func (NopObserver) OnRetry(context.Context, string, int, error)            {}
+func (NopObserver) OnError(context.Context, string, error)                 {}
+
+// Option configures a Client created with New, NewWithClient or NewWithOptions.
+type Option func(*Client)
+
+// WithRetry sets the retry policy used by Client.post.
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// WithRateLimit caps outbound requests to rps requests per second, allowing
+// bursts of up to burst requests.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(c *Client) { c.limiter = rate.NewLimiter(rate.Limit(rps), burst) }
+}
+
+// WithObserver wires o into the request lifecycle.
+func WithObserver(o Observer) Option {
+	return func(c *Client) { c.observer = o }
+}
+
+// WithHTTPClient overrides the http.Client used to make requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) { c.httpCli = client }
+}
+
+// WithSandboxURL overrides SandboxURL.
+func WithSandboxURL(url string) Option {
+	return func(c *Client) { c.SandboxURL = url }
+}
+
+// WithProductionURL overrides ProductionURL.
+func WithProductionURL(url string) Option {
+	return func(c *Client) { c.ProductionURL = url }
+}
+
+// retryableError marks an error returned by doOnce as safe to retry, and
+// optionally carries the delay requested by a Retry-After header.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isRetryable reports whether err should trigger another attempt: a 5xx
+// response from the App Store, or a network-level error reaching it (DNS
+// failure, timeout, connection reset, ...). Errors from building the
+// request, such as a JSON marshal failure, are not retryable - retrying
+// them would just fail the same way again.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var re *retryableError
+	if errors.As(err, &re) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func retryAfter(err error) time.Duration {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return re.retryAfter
+	}
+	return 0
+}
+
+// retryDelay computes an exponential backoff with full jitter, honoring a
+// server-requested Retry-After delay when present.
+func retryDelay(policy RetryPolicy, attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+
+	delay := policy.BaseDelay << uint(attempt-1)
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
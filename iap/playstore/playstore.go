@@ -0,0 +1,170 @@
+// Package playstore verifies Google Play purchases and subscriptions using
+// the Android Publisher API.
+package playstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/xsean2020/apple/iap"
+)
+
+const (
+	androidPublisherBaseURL = "https://androidpublisher.googleapis.com"
+	androidPublisherScope   = "https://www.googleapis.com/auth/androidpublisher"
+)
+
+// Config holds the credentials used to call the Android Publisher API.
+type Config struct {
+	// ServiceAccountJSON is the raw JSON key downloaded from the Google Cloud console.
+	ServiceAccountJSON []byte
+}
+
+// Client verifies Google Play purchases via purchases.products.get and
+// purchases.subscriptionsv2.get.
+type Client struct {
+	httpCli *http.Client
+}
+
+// New creates a Client authenticated with a Google service account.
+func New(ctx context.Context, cfg Config) (*Client, error) {
+	creds, err := google.CredentialsFromJSON(ctx, cfg.ServiceAccountJSON, androidPublisherScope)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{httpCli: oauth2.NewClient(ctx, creds.TokenSource)}, nil
+}
+
+// ProductPurchase is the response of purchases.products.get.
+type ProductPurchase struct {
+	PurchaseTimeMillis   string `json:"purchaseTimeMillis"`
+	PurchaseState        int    `json:"purchaseState"`
+	ConsumptionState     int    `json:"consumptionState"`
+	OrderID              string `json:"orderId"`
+	AcknowledgementState int    `json:"acknowledgementState"`
+}
+
+// SubscriptionLineItem is one entry of SubscriptionPurchaseV2.LineItems.
+type SubscriptionLineItem struct {
+	ProductID        string `json:"productId"`
+	ExpiryTime       string `json:"expiryTime"`
+	AutoRenewingPlan *struct {
+		AutoRenewEnabled bool `json:"autoRenewEnabled"`
+	} `json:"autoRenewingPlan,omitempty"`
+}
+
+// SubscriptionPurchaseV2 is the response of purchases.subscriptionsv2.get.
+type SubscriptionPurchaseV2 struct {
+	StartTime         string                 `json:"startTime"`
+	SubscriptionState string                 `json:"subscriptionState"`
+	LatestOrderID     string                 `json:"latestOrderId"`
+	LineItems         []SubscriptionLineItem `json:"lineItems"`
+}
+
+// GetProduct calls purchases.products.get for a one-time product purchase.
+func (c *Client) GetProduct(ctx context.Context, packageName, productID, token string) (*ProductPurchase, error) {
+	url := fmt.Sprintf("%s/androidpublisher/v3/applications/%s/purchases/products/%s/tokens/%s",
+		androidPublisherBaseURL, packageName, productID, token)
+	out := new(ProductPurchase)
+	if err := c.get(ctx, url, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetSubscriptionV2 calls purchases.subscriptionsv2.get for a subscription purchase.
+func (c *Client) GetSubscriptionV2(ctx context.Context, packageName, token string) (*SubscriptionPurchaseV2, error) {
+	url := fmt.Sprintf("%s/androidpublisher/v3/applications/%s/purchases/subscriptionsv2/tokens/%s",
+		androidPublisherBaseURL, packageName, token)
+	out := new(SubscriptionPurchaseV2)
+	if err := c.get(ctx, url, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *Client) get(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("playstore: status %d: %s", resp.StatusCode, buf)
+	}
+	return json.Unmarshal(buf, out)
+}
+
+// Verify implements iap.Verifier. req.PackageName and req.ReceiptData (the
+// purchase token) are required; set req.IsSubscription to verify a
+// subscription instead of a one-time product, in which case req.ProductID
+// is not needed.
+func (c *Client) Verify(ctx context.Context, req iap.VerifyRequest) (*iap.UnifiedReceipt, error) {
+	if req.IsSubscription {
+		return c.verifySubscription(ctx, req)
+	}
+	return c.verifyProduct(ctx, req)
+}
+
+func (c *Client) verifySubscription(ctx context.Context, req iap.VerifyRequest) (*iap.UnifiedReceipt, error) {
+	sub, err := c.GetSubscriptionV2(ctx, req.PackageName, req.ReceiptData)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &iap.UnifiedReceipt{
+		Store:   "playstore",
+		OrderID: sub.LatestOrderID,
+		Raw:     sub,
+	}
+	if t, err := time.Parse(time.RFC3339, sub.StartTime); err == nil {
+		ret.PurchaseTime = t
+	}
+	if len(sub.LineItems) > 0 {
+		item := sub.LineItems[0]
+		ret.ProductID = item.ProductID
+		if item.AutoRenewingPlan != nil {
+			ret.AutoRenewing = item.AutoRenewingPlan.AutoRenewEnabled
+		}
+		if t, err := time.Parse(time.RFC3339, item.ExpiryTime); err == nil {
+			ret.ExpiryTime = t
+		}
+	}
+	return ret, nil
+}
+
+func (c *Client) verifyProduct(ctx context.Context, req iap.VerifyRequest) (*iap.UnifiedReceipt, error) {
+	p, err := c.GetProduct(ctx, req.PackageName, req.ProductID, req.ReceiptData)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &iap.UnifiedReceipt{
+		Store:     "playstore",
+		ProductID: req.ProductID,
+		OrderID:   p.OrderID,
+		Raw:       p,
+	}
+	if ms, err := strconv.ParseInt(p.PurchaseTimeMillis, 10, 64); err == nil {
+		ret.PurchaseTime = time.UnixMilli(ms)
+	}
+	return ret, nil
+}
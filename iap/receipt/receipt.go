@@ -0,0 +1,279 @@
+// Package receipt implements offline validation of App Store receipts.
+//
+// It decodes the PKCS#7 container Apple attaches to every receipt, verifies
+// the signing chain up to Apple's root certificate, and walks the ASN.1 set
+// of receipt attributes into the same shape the now-deprecated verifyReceipt
+// endpoint returned. This lets callers validate a receipt without a network
+// round trip to Apple.
+package receipt
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"crypto/x509"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// AppleIncRootCertificate.pem must hold the genuine Apple Inc. Root
+// Certificate, downloaded from https://www.apple.com/certificateauthority/ -
+// every production receipt's PKCS#7 chain terminates there, and nowhere
+// else will verify.
+//
+//go:embed AppleIncRootCertificate.pem
+var appleRootCertPEM []byte
+
+// receipt attribute type numbers, as documented by Apple.
+const (
+	attrBundleID                   = 2
+	attrAppVersion                 = 3
+	attrOpaqueValue                = 4
+	attrSHA1Hash                   = 5
+	attrReceiptCreationDate        = 12
+	attrInApp                      = 17
+	attrOriginalApplicationVersion = 19
+	attrExpirationDate             = 21
+)
+
+// in-app attribute type numbers, as documented by Apple.
+const (
+	inAppQuantity              = 1701
+	inAppProductID             = 1702
+	inAppTransactionID         = 1703
+	inAppPurchaseDate          = 1704
+	inAppOriginalTransactionID = 1705
+	inAppOriginalPurchaseDate  = 1706
+	inAppExpiresDate           = 1708
+	inAppWebOrderLineItemID    = 1711
+	inAppCancellationDate      = 1712
+	inAppIsTrialPeriod         = 1713
+	inAppIsInIntroOfferPeriod  = 1719
+)
+
+// Status mirrors the documented verifyReceipt status codes so offline and
+// online verification share the same error surface.
+type Status int
+
+// Well-known receipt status codes, see HandleError for their online (HTTP)
+// equivalents.
+const (
+	StatusOK                      Status = 0
+	StatusInvalidJSON             Status = 21000
+	StatusInvalidReceiptData      Status = 21002
+	StatusReceiptUnauthenticated  Status = 21003
+	StatusInvalidSharedSecret     Status = 21004
+	StatusServerUnavailable       Status = 21005
+	StatusReceiptIsForTest        Status = 21007
+	StatusReceiptIsForProduction  Status = 21008
+	StatusInternalDataAccessError Status = 21009
+	StatusReceiptUnauthorized     Status = 21010
+)
+
+// IsRetryable reports whether the caller can reasonably retry verification
+// for this status, as opposed to the receipt itself being invalid.
+func (s Status) IsRetryable() bool {
+	switch {
+	case s == StatusServerUnavailable:
+		return true
+	case s >= 21100 && s <= 21199:
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	// ErrMalformedReceipt is returned when the receipt is not a valid PKCS#7
+	// SignedData structure.
+	ErrMalformedReceipt = errors.New("receipt: malformed PKCS#7 container")
+	// ErrUntrustedSignature is returned when the receipt's signing chain does
+	// not terminate at the trusted root certificate.
+	ErrUntrustedSignature = errors.New("receipt: signature does not chain to a trusted root")
+)
+
+// Receipt is the decoded set of attributes embedded in a receipt, matching
+// the JSON shape of the verifyReceipt "receipt" object.
+type Receipt struct {
+	BundleID                   string  `json:"bundle_id"`
+	ApplicationVersion         string  `json:"application_version"`
+	OpaqueValue                []byte  `json:"-"`
+	SHA1Hash                   []byte  `json:"-"`
+	OriginalApplicationVersion string  `json:"original_application_version"`
+	ReceiptCreationDate        string  `json:"receipt_creation_date"`
+	ExpirationDate             string  `json:"expiration_date,omitempty"`
+	InApp                      []InApp `json:"in_app"`
+}
+
+// InApp is a single in-app purchase attribute, matching the JSON shape of
+// entries in verifyReceipt's "in_app" array.
+type InApp struct {
+	Quantity              string `json:"quantity"`
+	ProductID             string `json:"product_id"`
+	TransactionID         string `json:"transaction_id"`
+	OriginalTransactionID string `json:"original_transaction_id"`
+	PurchaseDate          string `json:"purchase_date"`
+	OriginalPurchaseDate  string `json:"original_purchase_date"`
+	ExpiresDate           string `json:"expires_date,omitempty"`
+	WebOrderLineItemID    string `json:"web_order_line_item_id,omitempty"`
+	CancellationDate      string `json:"cancellation_date,omitempty"`
+	IsInIntroOfferPeriod  string `json:"is_in_intro_offer_period,omitempty"`
+	IsTrialPeriod         string `json:"is_trial_period,omitempty"`
+}
+
+// attribute mirrors the ASN.1 SEQUENCE { type INTEGER, version INTEGER,
+// value OCTET STRING } Apple encodes each receipt field as.
+type attribute struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+// unmarshalASN1Set decodes data as an ASN.1 SET OF attribute. It works
+// around encoding/asn1's inability to expect SET for a bare top-level value
+// by re-tagging the raw SET as a SEQUENCE, which decodes identically.
+func unmarshalASN1Set(data []byte, out *[]attribute) error {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSet {
+		return fmt.Errorf("expected an ASN.1 SET, got class %d tag %d", raw.Class, raw.Tag)
+	}
+
+	seq, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: raw.Bytes})
+	if err != nil {
+		return err
+	}
+
+	_, err = asn1.Unmarshal(seq, out)
+	return err
+}
+
+// DefaultAppleRootCert returns Apple's Inc. Root Certificate, embedded at
+// build time, for use as the trust anchor passed to ParseReceipt.
+func DefaultAppleRootCert() (*x509.Certificate, error) {
+	block, _ := pem.Decode(appleRootCertPEM)
+	if block == nil {
+		return nil, errors.New("receipt: failed to decode embedded root certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ParseReceipt verifies and decodes a base64 encoded App Store receipt.
+// rootCert is the trust anchor the signing chain must terminate at; pass
+// the result of DefaultAppleRootCert unless testing against a different
+// chain.
+func ParseReceipt(data []byte, rootCert *x509.Certificate) (*Receipt, error) {
+	raw := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(raw, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedReceipt, err)
+	}
+	raw = raw[:n]
+
+	p7, err := pkcs7.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedReceipt, err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(rootCert)
+
+	// VerifyWithChain verifies the signature and, given a non-nil
+	// truststore, that the signing (leaf) certificate chains to it through
+	// the other certificates bundled in the PKCS#7 container (the WWDR
+	// intermediate) - it does not need them passed separately.
+	if err := p7.VerifyWithChain(pool); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUntrustedSignature, err)
+	}
+
+	var attrs []attribute
+	if err := unmarshalASN1Set(p7.Content, &attrs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedReceipt, err)
+	}
+
+	return decodeAttributes(attrs)
+}
+
+func decodeAttributes(attrs []attribute) (*Receipt, error) {
+	r := new(Receipt)
+	for _, a := range attrs {
+		switch a.Type {
+		case attrBundleID:
+			r.BundleID = decodeASN1String(a.Value)
+		case attrAppVersion:
+			r.ApplicationVersion = decodeASN1String(a.Value)
+		case attrOpaqueValue:
+			r.OpaqueValue = a.Value
+		case attrSHA1Hash:
+			r.SHA1Hash = a.Value
+		case attrOriginalApplicationVersion:
+			r.OriginalApplicationVersion = decodeASN1String(a.Value)
+		case attrReceiptCreationDate:
+			r.ReceiptCreationDate = decodeASN1String(a.Value)
+		case attrExpirationDate:
+			r.ExpirationDate = decodeASN1String(a.Value)
+		case attrInApp:
+			inApp, err := decodeInApp(a.Value)
+			if err != nil {
+				return nil, err
+			}
+			r.InApp = append(r.InApp, *inApp)
+		}
+	}
+	return r, nil
+}
+
+func decodeInApp(value []byte) (*InApp, error) {
+	var attrs []attribute
+	if err := unmarshalASN1Set(value, &attrs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedReceipt, err)
+	}
+
+	inApp := new(InApp)
+	for _, a := range attrs {
+		switch a.Type {
+		case inAppQuantity:
+			inApp.Quantity = decodeASN1String(a.Value)
+		case inAppProductID:
+			inApp.ProductID = decodeASN1String(a.Value)
+		case inAppTransactionID:
+			inApp.TransactionID = decodeASN1String(a.Value)
+		case inAppOriginalTransactionID:
+			inApp.OriginalTransactionID = decodeASN1String(a.Value)
+		case inAppPurchaseDate:
+			inApp.PurchaseDate = decodeASN1String(a.Value)
+		case inAppOriginalPurchaseDate:
+			inApp.OriginalPurchaseDate = decodeASN1String(a.Value)
+		case inAppExpiresDate:
+			inApp.ExpiresDate = decodeASN1String(a.Value)
+		case inAppWebOrderLineItemID:
+			inApp.WebOrderLineItemID = decodeASN1String(a.Value)
+		case inAppCancellationDate:
+			inApp.CancellationDate = decodeASN1String(a.Value)
+		case inAppIsInIntroOfferPeriod:
+			inApp.IsInIntroOfferPeriod = decodeASN1String(a.Value)
+		case inAppIsTrialPeriod:
+			inApp.IsTrialPeriod = decodeASN1String(a.Value)
+		}
+	}
+	return inApp, nil
+}
+
+// decodeASN1String decodes a receipt attribute value that is itself an
+// ASN.1 encoded IA5String/UTF8String, falling back to the raw bytes if the
+// value was not re-encoded (some fields, like sha1_hash, are raw OCTET
+// STRINGs that callers read directly instead).
+func decodeASN1String(value []byte) string {
+	var s string
+	if _, err := asn1.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(value))
+}
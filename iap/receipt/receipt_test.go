@@ -0,0 +1,188 @@
+package receipt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// issueCert generates a new key pair and signs a certificate for tmpl with
+// parentKey, issued by parent, mirroring the root -> WWDR -> leaf chain a
+// real receipt is signed with.
+func issueCert(t *testing.T, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+// buildReceiptChain generates a synthetic root -> intermediate -> leaf chain
+// shaped like Apple's real one (root CA, WWDR-style intermediate, receipt
+// signing leaf), returning the root, intermediate and leaf certificates and
+// the leaf's private key.
+func buildReceiptChain(t *testing.T) (root, intermediate, leaf *x509.Certificate, leafKey *ecdsa.PrivateKey) {
+	t.Helper()
+
+	now := time.Now()
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate root key: %v", err)
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("create root certificate: %v", err)
+	}
+	root, err = x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatalf("parse root certificate: %v", err)
+	}
+
+	intermediateTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Test WWDR Intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	intermediate, intermediateKey := issueCert(t, intermediateTmpl, root, rootKey)
+
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "Test Receipt Signer"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	leaf, leafKey = issueCert(t, leafTmpl, intermediate, intermediateKey)
+
+	return root, intermediate, leaf, leafKey
+}
+
+// signReceiptAttrs wraps attrs as an ASN.1 SET OF attribute - the same shape
+// Apple encodes a receipt's payload as - and signs it as a PKCS7 SignedData,
+// the way Apple's receipt server produces the base64 blob ParseReceipt decodes.
+func signReceiptAttrs(t *testing.T, attrs []attribute, intermediate, leaf *x509.Certificate, leafKey *ecdsa.PrivateKey) []byte {
+	t.Helper()
+
+	seq, err := asn1.Marshal(attrs)
+	if err != nil {
+		t.Fatalf("marshal attributes: %v", err)
+	}
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(seq, &raw); err != nil {
+		t.Fatalf("unmarshal sequence: %v", err)
+	}
+	setBytes, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: raw.Bytes})
+	if err != nil {
+		t.Fatalf("marshal set: %v", err)
+	}
+
+	sd, err := pkcs7.NewSignedData(setBytes)
+	if err != nil {
+		t.Fatalf("new signed data: %v", err)
+	}
+	if err := sd.AddSignerChain(leaf, leafKey, []*x509.Certificate{intermediate}, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatalf("add signer: %v", err)
+	}
+
+	der, err := sd.Finish()
+	if err != nil {
+		t.Fatalf("finish signed data: %v", err)
+	}
+
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(der)))
+	base64.StdEncoding.Encode(out, der)
+	return out
+}
+
+func TestParseReceiptVerifiesChainAndDecodesSetOfAttributes(t *testing.T) {
+	root, intermediate, leaf, leafKey := buildReceiptChain(t)
+
+	inAppAttrs := []attribute{
+		{Type: inAppProductID, Value: mustMarshalASN1String(t, "com.example.coins")},
+		{Type: inAppTransactionID, Value: mustMarshalASN1String(t, "1000000012345678")},
+	}
+	inAppSeq, err := asn1.Marshal(inAppAttrs)
+	if err != nil {
+		t.Fatalf("marshal in-app attributes: %v", err)
+	}
+	var inAppRaw asn1.RawValue
+	if _, err := asn1.Unmarshal(inAppSeq, &inAppRaw); err != nil {
+		t.Fatalf("unmarshal in-app sequence: %v", err)
+	}
+	inAppSet, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: inAppRaw.Bytes})
+	if err != nil {
+		t.Fatalf("marshal in-app set: %v", err)
+	}
+
+	attrs := []attribute{
+		{Type: attrBundleID, Value: mustMarshalASN1String(t, "com.example.app")},
+		{Type: attrInApp, Value: inAppSet},
+	}
+
+	data := signReceiptAttrs(t, attrs, intermediate, leaf, leafKey)
+
+	got, err := ParseReceipt(data, root)
+	if err != nil {
+		t.Fatalf("ParseReceipt: %v", err)
+	}
+	if got.BundleID != "com.example.app" {
+		t.Errorf("BundleID = %q, want %q", got.BundleID, "com.example.app")
+	}
+	if len(got.InApp) != 1 {
+		t.Fatalf("len(InApp) = %d, want 1", len(got.InApp))
+	}
+	if got.InApp[0].ProductID != "com.example.coins" {
+		t.Errorf("InApp[0].ProductID = %q, want %q", got.InApp[0].ProductID, "com.example.coins")
+	}
+	if got.InApp[0].TransactionID != "1000000012345678" {
+		t.Errorf("InApp[0].TransactionID = %q, want %q", got.InApp[0].TransactionID, "1000000012345678")
+	}
+
+	otherRoot, _, _, _ := buildReceiptChain(t)
+	if _, err := ParseReceipt(data, otherRoot); err == nil {
+		t.Fatal("ParseReceipt with an unrelated root: got nil error, want ErrUntrustedSignature")
+	}
+}
+
+func mustMarshalASN1String(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := asn1.Marshal(s)
+	if err != nil {
+		t.Fatalf("marshal ASN.1 string: %v", err)
+	}
+	return b
+}
@@ -0,0 +1,557 @@
+package iap
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/xsean2020/apple/iap/notifications"
+)
+
+const (
+	// ServerAPIProductionURL is the host for the App Store Server API.
+	ServerAPIProductionURL string = "https://api.storekit.itunes.apple.com"
+	// ServerAPISandboxURL is the host for the App Store Server API sandbox environment.
+	ServerAPISandboxURL string = "https://api.storekit-sandbox.itunes.apple.com"
+
+	serverAPITokenAudience = "appstoreconnect-v1"
+	// serverAPITokenTTL is kept under Apple's one hour limit so a token is
+	// never presented within a few seconds of expiry.
+	serverAPITokenTTL = 55 * time.Minute
+)
+
+// list of ServerAPIClient specific errors
+var (
+	ErrMissingPrivateKey = errors.New("iap: ServerAPIConfig.PrivateKey is required")
+	ErrInvalidPrivateKey = errors.New("iap: private key is not a PKCS8 encoded ECDSA key")
+
+	ErrAccountNotFound               = errors.New("account not found")
+	ErrAppNotFound                   = errors.New("app not found")
+	ErrOriginalTransactionIDNotFound = errors.New("original transaction id not found")
+	ErrTransactionNotFound           = errors.New("transaction id not found")
+	ErrInvalidTransactionID          = errors.New("transaction id is not valid")
+	ErrInvalidAppAccountToken        = errors.New("app account token is not valid")
+	ErrRateLimitExceeded             = errors.New("rate limit exceeded")
+	ErrInvalidRequest                = errors.New("request is not valid")
+	ErrServerAPIUnknown              = errors.New("an unknown App Store Server API error occurred")
+)
+
+// serverAPIErrorCodes maps documented errorCode values to typed errors, the
+// same way HandleError maps verifyReceipt status codes.
+var serverAPIErrorCodes = map[int]error{
+	4000001: ErrInvalidRequest,
+	4000002: ErrInvalidTransactionID,
+	4000008: ErrInvalidAppAccountToken,
+	4040001: ErrAccountNotFound,
+	4040002: ErrAppNotFound,
+	4040004: ErrOriginalTransactionIDNotFound,
+	4040005: ErrTransactionNotFound,
+	4291000: ErrRateLimitExceeded,
+}
+
+// ServerAPIError is returned when the App Store Server API responds with a
+// non-2xx status. ErrorCode and ErrorMessage come straight from the response
+// body; Unwrap exposes one of the typed errors above when Apple's code is
+// documented.
+type ServerAPIError struct {
+	StatusCode   int
+	ErrorCode    int    `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+func (e *ServerAPIError) Error() string {
+	return fmt.Sprintf("app store server api: status %d errorCode %d: %s", e.StatusCode, e.ErrorCode, e.ErrorMessage)
+}
+
+func (e *ServerAPIError) Unwrap() error {
+	if err, ok := serverAPIErrorCodes[e.ErrorCode]; ok {
+		return err
+	}
+	return ErrServerAPIUnknown
+}
+
+// ServerAPIConfig holds the credentials required to call the App Store
+// Server API on behalf of one bundle ID.
+type ServerAPIConfig struct {
+	// IssuerID is the issuer ID from the Keys page in App Store Connect.
+	IssuerID string
+	// KeyID is the identifier of the private key downloaded from App Store Connect.
+	KeyID string
+	// BundleID is the app's bundle identifier.
+	BundleID string
+	// PrivateKey is the ECDSA P-256 private key matching KeyID.
+	PrivateKey *ecdsa.PrivateKey
+	// IsProductionEnv selects ServerAPIProductionURL over ServerAPISandboxURL.
+	IsProductionEnv bool
+	// HTTPClient is used to make requests. A client with a 10s timeout is used if nil.
+	HTTPClient *http.Client
+	// RootCert is the trust anchor signedTransactionInfo/signedRenewalInfo
+	// JWS responses must chain to. Defaults to
+	// notifications.DefaultAppleRootCAG3() - the App Store Server API signs
+	// with Apple Root CA - G3, not the PKCS#7 receipt root.
+	RootCert *x509.Certificate
+}
+
+// LoadPrivateKey parses a PEM encoded PKCS8 private key, as downloaded from
+// App Store Connect, into an ECDSA private key suitable for ServerAPIConfig.
+func LoadPrivateKey(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("iap: failed to decode PEM block containing private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+	return ecKey, nil
+}
+
+// ServerAPIClient talks to Apple's App Store Server API, authenticating each
+// request with a JWT signed using the ES256 algorithm.
+type ServerAPIClient struct {
+	cfg     ServerAPIConfig
+	httpCli *http.Client
+
+	mu        sync.Mutex
+	jwt       string
+	jwtExpiry time.Time
+}
+
+// NewServerAPI creates a client for the App Store Server API.
+func NewServerAPI(cfg ServerAPIConfig) (*ServerAPIClient, error) {
+	if cfg.PrivateKey == nil {
+		return nil, ErrMissingPrivateKey
+	}
+
+	httpCli := cfg.HTTPClient
+	if httpCli == nil {
+		httpCli = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if cfg.RootCert == nil {
+		rootCert, err := notifications.DefaultAppleRootCAG3()
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCert = rootCert
+	}
+
+	return &ServerAPIClient{cfg: cfg, httpCli: httpCli}, nil
+}
+
+func (c *ServerAPIClient) baseURL() string {
+	if c.cfg.IsProductionEnv {
+		return ServerAPIProductionURL
+	}
+	return ServerAPISandboxURL
+}
+
+// token returns a signed JWT, reusing the previous one until it is close to
+// expiry.
+func (c *ServerAPIClient) token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.jwt != "" && time.Now().Before(c.jwtExpiry) {
+		return c.jwt, nil
+	}
+
+	now := time.Now()
+	exp := now.Add(serverAPITokenTTL)
+
+	signingInput, err := jwtSigningInput(
+		map[string]interface{}{
+			"alg": "ES256",
+			"kid": c.cfg.KeyID,
+			"typ": "JWT",
+		},
+		map[string]interface{}{
+			"iss": c.cfg.IssuerID,
+			"iat": now.Unix(),
+			"exp": exp.Unix(),
+			"aud": serverAPITokenAudience,
+			"bid": c.cfg.BundleID,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := signES256(c.cfg.PrivateKey, signingInput)
+	if err != nil {
+		return "", err
+	}
+
+	c.jwt = signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+	c.jwtExpiry = exp.Add(-1 * time.Minute)
+	return c.jwt, nil
+}
+
+func jwtSigningInput(header, claims map[string]interface{}) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p), nil
+}
+
+// signES256 signs signingInput with the ES256 algorithm, returning the raw
+// r||s signature JWS expects (as opposed to the ASN.1 form ecdsa.Sign's
+// result is usually marshaled into).
+func signES256(key *ecdsa.PrivateKey, signingInput string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	sig := make([]byte, 2*size)
+	r.FillBytes(sig[:size])
+	s.FillBytes(sig[size:])
+	return sig, nil
+}
+
+func (c *ServerAPIClient) do(ctx context.Context, method, path string, query url.Values, body interface{}, out interface{}) error {
+	tok, err := c.token()
+	if err != nil {
+		return err
+	}
+
+	reqURL := c.baseURL() + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+
+	var reader *bytes.Reader
+	if body != nil {
+		bts, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(bts)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	if body != nil {
+		req.Header.Set("Content-Type", ContentType)
+	}
+
+	resp, err := c.httpCli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &ServerAPIError{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(buf, apiErr)
+		return apiErr
+	}
+
+	if out == nil || len(buf) == 0 {
+		return nil
+	}
+	return json.Unmarshal(buf, out)
+}
+
+// decodeTransaction verifies and decodes a single signedTransactionInfo JWS.
+func (c *ServerAPIClient) decodeTransaction(signed string) (*notifications.JWSTransactionDecodedPayload, error) {
+	out := new(notifications.JWSTransactionDecodedPayload)
+	if err := notifications.DecodeJWS(signed, c.cfg.RootCert, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeRenewalInfo verifies and decodes a single signedRenewalInfo JWS.
+func (c *ServerAPIClient) decodeRenewalInfo(signed string) (*notifications.JWSRenewalInfoDecodedPayload, error) {
+	out := new(notifications.JWSRenewalInfoDecodedPayload)
+	if err := notifications.DecodeJWS(signed, c.cfg.RootCert, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeTransactions verifies and decodes a batch of signedTransactionInfo JWS strings.
+func (c *ServerAPIClient) decodeTransactions(signed []string) ([]*notifications.JWSTransactionDecodedPayload, error) {
+	out := make([]*notifications.JWSTransactionDecodedPayload, len(signed))
+	for i, s := range signed {
+		tx, err := c.decodeTransaction(s)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = tx
+	}
+	return out, nil
+}
+
+// transactionInfoResponse is the raw wire response of GetTransactionInfo.
+type transactionInfoResponse struct {
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+}
+
+// GetTransactionInfo retrieves, verifies and decodes information for a
+// single transaction.
+func (c *ServerAPIClient) GetTransactionInfo(ctx context.Context, transactionID string) (*notifications.JWSTransactionDecodedPayload, error) {
+	raw := new(transactionInfoResponse)
+	if err := c.do(ctx, http.MethodGet, "/inApps/v1/transactions/"+transactionID, nil, nil, raw); err != nil {
+		return nil, err
+	}
+	return c.decodeTransaction(raw.SignedTransactionInfo)
+}
+
+// historyResponse is the raw wire response of GetTransactionHistory.
+type historyResponse struct {
+	AppAppleID         int64    `json:"appAppleId"`
+	BundleID           string   `json:"bundleId"`
+	Environment        string   `json:"environment"`
+	HasMore            bool     `json:"hasMore"`
+	Revision           string   `json:"revision"`
+	SignedTransactions []string `json:"signedTransactions"`
+}
+
+// HistoryResult is the verified, decoded response of GetTransactionHistory.
+type HistoryResult struct {
+	AppAppleID   int64
+	BundleID     string
+	Environment  string
+	HasMore      bool
+	Revision     string
+	Transactions []*notifications.JWSTransactionDecodedPayload
+}
+
+// GetTransactionHistory retrieves a customer's in-app purchase history for
+// an app. Pass the revision returned by a previous call in query to fetch
+// the next page.
+func (c *ServerAPIClient) GetTransactionHistory(ctx context.Context, transactionID string, query url.Values) (*HistoryResult, error) {
+	raw := new(historyResponse)
+	if err := c.do(ctx, http.MethodGet, "/inApps/v1/history/"+transactionID, query, nil, raw); err != nil {
+		return nil, err
+	}
+
+	txs, err := c.decodeTransactions(raw.SignedTransactions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HistoryResult{
+		AppAppleID:   raw.AppAppleID,
+		BundleID:     raw.BundleID,
+		Environment:  raw.Environment,
+		HasMore:      raw.HasMore,
+		Revision:     raw.Revision,
+		Transactions: txs,
+	}, nil
+}
+
+// lastTransactionItem is the raw wire form of a subscription group's most recent transaction.
+type lastTransactionItem struct {
+	OriginalTransactionID string `json:"originalTransactionId"`
+	Status                int    `json:"status"`
+	SignedRenewalInfo     string `json:"signedRenewalInfo"`
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+}
+
+// subscriptionGroupIdentifierItem is the raw wire form of one subscription group's statuses.
+type subscriptionGroupIdentifierItem struct {
+	SubscriptionGroupIdentifier string                `json:"subscriptionGroupIdentifier"`
+	LastTransactions            []lastTransactionItem `json:"lastTransactions"`
+}
+
+// subscriptionStatusesResponse is the raw wire response of GetAllSubscriptionStatuses.
+type subscriptionStatusesResponse struct {
+	Environment string                            `json:"environment"`
+	AppAppleID  int64                             `json:"appAppleId"`
+	BundleID    string                            `json:"bundleId"`
+	Data        []subscriptionGroupIdentifierItem `json:"data"`
+}
+
+// LastTransaction is the verified, decoded form of a subscription group's most recent transaction.
+type LastTransaction struct {
+	OriginalTransactionID string
+	Status                int
+	RenewalInfo           *notifications.JWSRenewalInfoDecodedPayload
+	TransactionInfo       *notifications.JWSTransactionDecodedPayload
+}
+
+// SubscriptionGroupStatus groups the decoded last transactions of a subscription group.
+type SubscriptionGroupStatus struct {
+	SubscriptionGroupIdentifier string
+	LastTransactions            []LastTransaction
+}
+
+// SubscriptionStatusesResult is the verified, decoded response of GetAllSubscriptionStatuses.
+type SubscriptionStatusesResult struct {
+	Environment string
+	AppAppleID  int64
+	BundleID    string
+	Data        []SubscriptionGroupStatus
+}
+
+// GetAllSubscriptionStatuses returns the statuses for all of a customer's
+// subscriptions in an app, grouped by subscription group.
+func (c *ServerAPIClient) GetAllSubscriptionStatuses(ctx context.Context, transactionID string) (*SubscriptionStatusesResult, error) {
+	raw := new(subscriptionStatusesResponse)
+	if err := c.do(ctx, http.MethodGet, "/inApps/v1/subscriptions/"+transactionID, nil, nil, raw); err != nil {
+		return nil, err
+	}
+
+	result := &SubscriptionStatusesResult{
+		Environment: raw.Environment,
+		AppAppleID:  raw.AppAppleID,
+		BundleID:    raw.BundleID,
+	}
+
+	for _, group := range raw.Data {
+		decodedGroup := SubscriptionGroupStatus{SubscriptionGroupIdentifier: group.SubscriptionGroupIdentifier}
+		for _, item := range group.LastTransactions {
+			tx, err := c.decodeTransaction(item.SignedTransactionInfo)
+			if err != nil {
+				return nil, err
+			}
+			renewal, err := c.decodeRenewalInfo(item.SignedRenewalInfo)
+			if err != nil {
+				return nil, err
+			}
+			decodedGroup.LastTransactions = append(decodedGroup.LastTransactions, LastTransaction{
+				OriginalTransactionID: item.OriginalTransactionID,
+				Status:                item.Status,
+				RenewalInfo:           renewal,
+				TransactionInfo:       tx,
+			})
+		}
+		result.Data = append(result.Data, decodedGroup)
+	}
+	return result, nil
+}
+
+// orderLookupResponse is the raw wire response of LookUpOrderId.
+type orderLookupResponse struct {
+	Status             int      `json:"status"`
+	SignedTransactions []string `json:"signedTransactions"`
+}
+
+// OrderLookupResult is the verified, decoded response of LookUpOrderId.
+type OrderLookupResult struct {
+	Status       int
+	Transactions []*notifications.JWSTransactionDecodedPayload
+}
+
+// LookUpOrderId retrieves transactions for a customer's order ID, typically
+// used to resolve a customer support refund request.
+func (c *ServerAPIClient) LookUpOrderId(ctx context.Context, orderID string) (*OrderLookupResult, error) {
+	raw := new(orderLookupResponse)
+	if err := c.do(ctx, http.MethodGet, "/inApps/v1/lookup/"+orderID, nil, nil, raw); err != nil {
+		return nil, err
+	}
+
+	txs, err := c.decodeTransactions(raw.SignedTransactions)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderLookupResult{Status: raw.Status, Transactions: txs}, nil
+}
+
+// ConsumptionRequest is the body of SendConsumptionInformation.
+type ConsumptionRequest struct {
+	AccountTenure            int    `json:"accountTenure"`
+	AppAccountToken          string `json:"appAccountToken"`
+	ConsumptionStatus        int    `json:"consumptionStatus"`
+	CustomerConsented        bool   `json:"customerConsented"`
+	DeliveryStatus           int    `json:"deliveryStatus"`
+	LifetimeDollarsPurchased int    `json:"lifetimeDollarsPurchased"`
+	LifetimeDollarsRefunded  int    `json:"lifetimeDollarsRefunded"`
+	Platform                 int    `json:"platform"`
+	PlayTime                 int    `json:"playTime"`
+	SampleContentProvided    bool   `json:"sampleContentProvided"`
+	UserStatus               int    `json:"userStatus"`
+}
+
+// SendConsumptionInformation reports information about a consumable in-app
+// purchase to help Apple make a decision on a refund request.
+func (c *ServerAPIClient) SendConsumptionInformation(ctx context.Context, transactionID string, req ConsumptionRequest) error {
+	return c.do(ctx, http.MethodPut, "/inApps/v1/transactions/consumption/"+transactionID, nil, req, nil)
+}
+
+// TestNotificationResponse is the response of RequestTestNotification.
+type TestNotificationResponse struct {
+	TestNotificationToken string `json:"testNotificationToken"`
+}
+
+// RequestTestNotification asks Apple to send a test version of a Server
+// Notification to the currently configured URL.
+func (c *ServerAPIClient) RequestTestNotification(ctx context.Context) (*TestNotificationResponse, error) {
+	out := new(TestNotificationResponse)
+	if err := c.do(ctx, http.MethodPost, "/inApps/v1/notifications/test", nil, nil, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// refundHistoryResponse is the raw wire response of GetRefundHistory.
+type refundHistoryResponse struct {
+	SignedTransactions []string `json:"signedTransactions"`
+	Revision           string   `json:"revision"`
+	HasMore            bool     `json:"hasMore"`
+}
+
+// RefundHistoryResult is the verified, decoded response of GetRefundHistory.
+type RefundHistoryResult struct {
+	Transactions []*notifications.JWSTransactionDecodedPayload
+	Revision     string
+	HasMore      bool
+}
+
+// GetRefundHistory retrieves a customer's refunded transactions for an app.
+// Pass the revision returned by a previous call to fetch the next page.
+func (c *ServerAPIClient) GetRefundHistory(ctx context.Context, transactionID, revision string) (*RefundHistoryResult, error) {
+	query := url.Values{}
+	if revision != "" {
+		query.Set("revision", revision)
+	}
+	raw := new(refundHistoryResponse)
+	if err := c.do(ctx, http.MethodGet, "/inApps/v2/refund/lookup/"+transactionID, query, nil, raw); err != nil {
+		return nil, err
+	}
+
+	txs, err := c.decodeTransactions(raw.SignedTransactions)
+	if err != nil {
+		return nil, err
+	}
+	return &RefundHistoryResult{Transactions: txs, Revision: raw.Revision, HasMore: raw.HasMore}, nil
+}
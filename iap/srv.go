@@ -6,10 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"time"
+
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -27,6 +28,10 @@ type Client struct {
 	ProductionURL   string
 	IsProductionEnv bool
 	httpCli         *http.Client
+
+	retry    RetryPolicy
+	limiter  *rate.Limiter
+	observer Observer
 }
 
 // list of errore
@@ -91,6 +96,8 @@ func New(IsProduction bool) *Client {
 		httpCli: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		retry:    DefaultRetryPolicy,
+		observer: NopObserver{},
 	}
 	return client
 }
@@ -102,60 +109,142 @@ func NewWithClient(client *http.Client, IsProduction bool) *Client {
 		SandboxURL:      SandboxURL,
 		ProductionURL:   ProductionURL,
 		httpCli:         client,
+		retry:           DefaultRetryPolicy,
+		observer:        NopObserver{},
+	}
+}
+
+// NewWithOptions creates a client configured with functional options, such
+// as WithRetry, WithRateLimit and WithObserver. Options not supplied fall
+// back to the same defaults as New.
+func NewWithOptions(IsProduction bool, opts ...Option) *Client {
+	client := New(IsProduction)
+	for _, opt := range opts {
+		opt(client)
 	}
+	return client
 }
 
 type Service interface {
 	Verify(receipt, txid string) (*InApp, error)
 }
 
-func (c *Client) post(ctx context.Context, url string, reader io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest("POST", url, reader)
+// post sends bodyBytes to url, retrying according to c.retry on 5xx and
+// network errors (honoring a Retry-After header when present), and reports
+// each attempt to c.observer.
+func (c *Client) post(ctx context.Context, url string, bodyBytes []byte) (*http.Response, error) {
+	policy := c.retry
+	if policy.MaxAttempts <= 0 {
+		policy = DefaultRetryPolicy
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.doOnce(ctx, url, bodyBytes, policy.PerAttemptTimeout)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		if attempt == policy.MaxAttempts || !isRetryable(err) {
+			break
+		}
+
+		c.observer.OnRetry(ctx, url, attempt, err)
+		if werr := sleepWithContext(ctx, retryDelay(policy, attempt, retryAfter(err))); werr != nil {
+			return nil, werr
+		}
+	}
+
+	c.observer.OnError(ctx, url, lastErr)
+	return nil, lastErr
+}
+
+func (c *Client) doOnce(ctx context.Context, url string, bodyBytes []byte, perAttemptTimeout time.Duration) (*http.Response, error) {
+	attemptCtx := ctx
+	if perAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, perAttemptTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return nil, err
 	}
-
 	req.Header.Set("Content-Type", ContentType)
-	req = req.WithContext(ctx)
+	req = req.WithContext(attemptCtx)
+
+	c.observer.OnRequest(ctx, url)
+	start := time.Now()
 	resp, err := c.httpCli.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	c.observer.OnResponse(ctx, url, resp.StatusCode, time.Since(start))
 
 	if resp.StatusCode >= 500 {
 		resp.Body.Close()
-		return nil, fmt.Errorf("received http status code %d from the App Store: %w", resp.StatusCode, ErrAppStoreServer)
+		return nil, &retryableError{
+			err:        fmt.Errorf("received http status code %d from the App Store: %w", resp.StatusCode, ErrAppStoreServer),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	// Read the body while attemptCtx is still live: cancelling it on return
+	// would otherwise abort the caller's read of the body.
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
 	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf))
 	return resp, nil
 }
 
 // Verify sends receipts and gets validation result
 func (c *Client) Verify(receipt, txid string) (*IAPResponse, error) {
+	result, _, err := c.verify(context.Background(), receipt, txid)
+	return result, err
+}
+
+// VerifyWithEnvironment behaves like Verify but additionally reports which
+// environment ("production" or "sandbox") actually served the receipt. The
+// receipt is always sent to ProductionURL first; the result is "sandbox" only
+// when a 21007 status caused an automatic retry against SandboxURL, which
+// happens only when IsProductionEnv is false. A 21008 status is not retried.
+func (c *Client) VerifyWithEnvironment(ctx context.Context, receipt, txid string) (*IAPResponse, string, error) {
+	return c.verify(ctx, receipt, txid)
+}
+
+func (c *Client) verify(ctx context.Context, receipt, txid string) (*IAPResponse, string, error) {
 	bts, err := json.Marshal(IAPRequest{
 		ReceiptData: receipt})
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	var req = bytes.NewBuffer(bts)
-	ctx := context.Background()
-	resp, err := c.post(ctx, c.ProductionURL, req)
+	resp, err := c.post(ctx, c.ProductionURL, bts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-
 	defer resp.Body.Close()
+
 	var result = new(IAPResponse)
-	req.Reset()
-	req.Write(bts)
-	if err = c.parseResponse(resp, result, ctx, req); err != nil {
-		return nil, err
+	servedBy, err := c.parseResponse(ctx, resp, result, bts)
+	if err != nil {
+		return nil, servedBy, err
 	}
 
-	err = HandleError(result.Status)
-	if err != nil {
-		return nil, err
+	if err := HandleError(result.Status); err != nil {
+		return nil, servedBy, err
 	}
 
 	var ret []InApp
@@ -165,38 +254,49 @@ func (c *Client) Verify(receipt, txid string) (*IAPResponse, error) {
 		}
 	}
 	if len(ret) == 0 {
-		return nil, ErrNotfound
+		return nil, servedBy, ErrNotfound
 	}
 	result.Receipt.InApp = ret
-	return result, nil
+	result.ServedBy = servedBy
+	return result, servedBy, nil
 }
 
-func (c *Client) parseResponse(resp *http.Response, result interface{}, ctx context.Context, body io.Reader) error {
+// parseResponse decodes resp into result, transparently retrying against the
+// sandbox host on a 21007 status as verifyReceipt's documentation instructs,
+// when IsProductionEnv is false. It returns which environment ultimately
+// served the receipt.
+func (c *Client) parseResponse(ctx context.Context, resp *http.Response, result interface{}, bodyBytes []byte) (string, error) {
+	servedBy := "production"
+
 	// Read the body now so that we can unmarshal it twice
 	buf, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return servedBy, err
 	}
 
 	// https://developer.apple.com/library/content/technotes/tn2413/_index.html#//apple_ref/doc/uid/DTS40016228-CH1-RECEIPTURL
 	var r StatusResponse
 	err = json.Unmarshal(buf, &r)
 	if err != nil {
-		return err
+		return servedBy, err
 	}
 
 	if r.Status == 21007 && !c.IsProductionEnv {
-		resp, err := c.post(ctx, c.SandboxURL, body)
+		c.observer.OnRetry(ctx, c.SandboxURL, 1, fmt.Errorf("status 21007: retrying against sandbox environment"))
+		resp, err := c.post(ctx, c.SandboxURL, bodyBytes)
 		if err != nil {
-			return err
+			return servedBy, err
 		}
 		defer resp.Body.Close()
 		buf, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return servedBy, err
+		}
+		servedBy = "sandbox"
 	}
 
-	err = json.Unmarshal(buf, &result)
-	if err != nil {
-		return err
+	if err := json.Unmarshal(buf, &result); err != nil {
+		return servedBy, err
 	}
-	return err
+	return servedBy, nil
 }
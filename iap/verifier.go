@@ -0,0 +1,86 @@
+package iap
+
+import (
+	"context"
+	"time"
+)
+
+// UnifiedReceipt normalizes a purchase receipt across app stores so callers
+// can process Apple, Google Play, Amazon and Huawei purchases through a
+// single shape instead of branching on the originating store.
+type UnifiedReceipt struct {
+	// Store identifies which Verifier produced this receipt, e.g. "apple",
+	// "playstore", "amazon" or "hms".
+	Store string
+
+	ProductID             string
+	TransactionID         string
+	OriginalTransactionID string
+	OrderID               string
+
+	PurchaseTime time.Time
+	ExpiryTime   time.Time
+	AutoRenewing bool
+	Environment  string
+
+	// Raw holds the provider-specific response the normalized fields above
+	// were derived from, for callers that need more than the common shape.
+	Raw interface{}
+}
+
+// VerifyRequest carries everything a Verifier implementation might need to
+// validate a purchase. Providers read only the fields relevant to them; see
+// each Verifier's doc comment for which ones it requires.
+type VerifyRequest struct {
+	// ReceiptData is the opaque receipt/token the client SDK produced:
+	// the base64 receipt for Apple, the purchase token for Play/HMS, or the
+	// receipt ID for Amazon.
+	ReceiptData string
+	// TransactionID disambiguates a receipt that can describe multiple
+	// transactions (Apple), or carries the Amazon user ID.
+	TransactionID string
+	ProductID     string
+	// PackageName is the Android application ID, required by playstore.
+	PackageName string
+	// IsSubscription selects the subscription API over the one-time
+	// product API where a provider distinguishes between them.
+	IsSubscription bool
+}
+
+// Verifier validates a purchase receipt against a specific app store and
+// returns it in a normalized shape.
+type Verifier interface {
+	Verify(ctx context.Context, req VerifyRequest) (*UnifiedReceipt, error)
+}
+
+// AppleVerifier adapts Client to the Verifier interface.
+type AppleVerifier struct {
+	Client *Client
+}
+
+// NewAppleVerifier wraps an existing Apple Client as a Verifier.
+func NewAppleVerifier(c *Client) *AppleVerifier {
+	return &AppleVerifier{Client: c}
+}
+
+// Verify implements Verifier. req.ReceiptData is the base64 receipt and
+// req.TransactionID the transaction ID to extract from it.
+func (v *AppleVerifier) Verify(ctx context.Context, req VerifyRequest) (*UnifiedReceipt, error) {
+	resp, env, err := v.Client.VerifyWithEnvironment(ctx, req.ReceiptData, req.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &UnifiedReceipt{
+		Store:       "apple",
+		Environment: env,
+		Raw:         resp,
+	}
+	if len(resp.Receipt.InApp) > 0 {
+		inApp := resp.Receipt.InApp[0]
+		ret.ProductID = inApp.ProductID
+		ret.TransactionID = inApp.TransactionID
+		ret.OriginalTransactionID = inApp.OriginalTransactionID
+	}
+	return ret, nil
+}